@@ -20,17 +20,20 @@
 // @license GPL-3.0+ <http://spdx.org/licenses/GPL-3.0+>
 //
 //
-// wikidatatojson converts a wikidata XML dump to JSON.
+// wikidatatojson converts a wikidata dump (XML or line-delimited JSON) to JSON.
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
-	"encoding/xml"
 	"flag"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/pprof"
@@ -40,48 +43,238 @@ import (
 	"github.com/miku/wikitools"
 )
 
-// WikidataJsonConverter converts XML pages into line delimited JSON
-func WikidataJsonConverter(in chan *wikitools.Page, out chan *string, filter *regexp.Regexp, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var container interface{}
+// Projection narrows the entity shape (claims, labels, descriptions, aliases, sitelinks) that
+// normalizeEntity keeps, so callers can pull a compact slice out of a full dump in one pass
+// instead of re-marshaling far more JSON than they need. A nil/empty set in any field means
+// "keep everything" for that field.
+type Projection struct {
+	Properties map[string]bool
+	Sitelinks  map[string]bool
+	Languages  map[string]bool
+}
 
-	for page := range in {
-		canonicalTitle := wikitools.CanonicalizeTitle(page.Title)
-		m := filter.MatchString(canonicalTitle)
-		if !m && page.Redir.Title == "" {
-			dec := json.NewDecoder(strings.NewReader(page.Text))
-			dec.UseNumber()
+// empty reports whether the projection has no restrictions at all, i.e. normalizeEntity can
+// skip the container walk entirely.
+func (p Projection) empty() bool {
+	return len(p.Properties) == 0 && len(p.Sitelinks) == 0 && len(p.Languages) == 0
+}
 
-			if err := dec.Decode(&container); err == io.EOF {
-				continue
-			} else if err != nil {
-				fmt.Errorf("%s\n", err)
-				continue
-			}
+// parseCommaSet turns a comma-separated flag value ("P31,P279") into a lookup set, or nil for
+// an empty string so callers can treat it as "no restriction".
+func parseCommaSet(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			set[part] = true
+		}
+	}
+	return set
+}
 
-			parsed := wikitools.WikidataPage{Title: page.Title,
-				CanonicalTitle: canonicalTitle,
-				Content:        container,
-				Redir:          page.Redir,
+// apply walks the standard Wikidata entity shape in container and drops the claims,
+// sitelinks, labels, descriptions and aliases not listed in the projection. container is
+// mutated in place; entities that aren't a JSON object (e.g. malformed dumps) are left as-is.
+func (p Projection) apply(container interface{}) {
+	m, ok := container.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if len(p.Properties) > 0 {
+		if claims, ok := m["claims"].(map[string]interface{}); ok {
+			for prop := range claims {
+				if !p.Properties[prop] {
+					delete(claims, prop)
+				}
 			}
-
-			b, err := json.Marshal(parsed)
-			if err != nil {
-				fmt.Errorf("%s\n", err)
+		}
+	}
+	if len(p.Sitelinks) > 0 {
+		if sitelinks, ok := m["sitelinks"].(map[string]interface{}); ok {
+			for site := range sitelinks {
+				if !p.Sitelinks[site] {
+					delete(sitelinks, site)
+				}
+			}
+		}
+	}
+	if len(p.Languages) > 0 {
+		for _, key := range []string{"labels", "descriptions", "aliases"} {
+			field, ok := m[key].(map[string]interface{})
+			if !ok {
 				continue
 			}
-			line := string(b)
-			out <- &line
+			for lang := range field {
+				if !p.Languages[lang] {
+					delete(field, lang)
+				}
+			}
 		}
 	}
 }
 
+// ConversionResult pairs a canonical entity title (e.g. "Q42") with its serialized JSON
+// line, so the fan-in writer can route it to a shard deterministically without having to
+// re-parse the line.
+type ConversionResult struct {
+	CanonicalTitle string
+	Line           string
+}
+
+// normalizeEntity decodes page.Text (already JSON, whether it is the inner text of an XML
+// dump's <page> element or a line straight out of a JSON dump) into a WikidataPage, applying
+// the title filter, redirect check and projection shared by every InputSource. A nil result
+// with a nil error means the page was filtered out.
+func normalizeEntity(page *wikitools.Page, filter *regexp.Regexp, projection Projection) (*ConversionResult, error) {
+	canonicalTitle := wikitools.CanonicalizeTitle(page.Title)
+	if filter.MatchString(canonicalTitle) || page.Redir.Title != "" {
+		return nil, nil
+	}
+
+	var container interface{}
+	dec := json.NewDecoder(strings.NewReader(page.Text))
+	dec.UseNumber()
+	if err := dec.Decode(&container); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !projection.empty() {
+		projection.apply(container)
+	}
+
+	parsed := wikitools.WikidataPage{Title: page.Title,
+		CanonicalTitle: canonicalTitle,
+		Content:        container,
+		Redir:          page.Redir,
+	}
+
+	b, err := json.Marshal(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return &ConversionResult{CanonicalTitle: canonicalTitle, Line: string(b)}, nil
+}
+
+// WikidataJsonConverter normalizes pages read from any wikitools.InputSource into line
+// delimited JSON.
+func WikidataJsonConverter(in chan *wikitools.Page, out chan *ConversionResult, filter *regexp.Regexp, projection Projection, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for page := range in {
+		result, err := normalizeEntity(page, filter, projection)
+		if err != nil {
+			log.Printf("skipping malformed entity: %s", err)
+			continue
+		}
+		if result != nil {
+			out <- result
+		}
+	}
+}
+
+// shardWriter is one gzip-compressed output shard that a ShardedWriter routes results into.
+type shardWriter struct {
+	file *os.File
+	gzip *gzip.Writer
+	buf  *bufio.Writer
+}
+
+// ShardedWriter fans conversion results out across N gzip-compressed files in dir
+// (part-00000.json.gz ...), routing each result to shard crc32(CanonicalTitle) % N so a given
+// entity always lands in the same file across runs.
+type ShardedWriter struct {
+	shards []*shardWriter
+}
+
+// NewShardedWriter creates n shard files named "part-NNNNN.json.gz" inside dir.
+func NewShardedWriter(dir string, n int) (*ShardedWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	sw := &ShardedWriter{shards: make([]*shardWriter, n)}
+	for i := 0; i < n; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("part-%05d.json.gz", i)))
+		if err != nil {
+			sw.Close()
+			return nil, err
+		}
+		gz := gzip.NewWriter(f)
+		sw.shards[i] = &shardWriter{file: f, gzip: gz, buf: bufio.NewWriter(gz)}
+	}
+	return sw, nil
+}
+
+// Write routes a single result to its shard and appends a trailing newline.
+func (sw *ShardedWriter) Write(r *ConversionResult) error {
+	idx := crc32.ChecksumIEEE([]byte(r.CanonicalTitle)) % uint32(len(sw.shards))
+	s := sw.shards[idx]
+	if _, err := s.buf.WriteString(r.Line); err != nil {
+		return err
+	}
+	return s.buf.WriteByte('\n')
+}
+
+// Close flushes and closes every shard, returning the first error encountered.
+func (sw *ShardedWriter) Close() error {
+	var firstErr error
+	for _, s := range sw.shards {
+		if s == nil {
+			continue
+		}
+		if err := s.buf.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := s.gzip.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := s.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// fanInSharded drains results into a ShardedWriter until results is closed, then signals done.
+func fanInSharded(results chan *ConversionResult, sw *ShardedWriter, done chan bool) {
+	for r := range results {
+		if err := sw.Write(r); err != nil {
+			log.Printf("shard write failed: %s", err)
+		}
+	}
+	done <- true
+}
+
+// fanInStdout drains results to os.Stdout via wikitools.FanInLineWriter until results is
+// closed, then signals done.
+func fanInStdout(results chan *ConversionResult, done chan bool) {
+	lines := make(chan *string)
+	go wikitools.FanInLineWriter(os.Stdout, lines, done)
+	for r := range results {
+		line := r.Line
+		lines <- &line
+	}
+	close(lines)
+}
+
 func main() {
 
 	filter := flag.String("filter", "^file:.*|^talk:.*|^special:.*|^wikipedia:.*|^wiktionary:.*|^user:.*|^user_talk:.*", "regex for pages to skip")
 	version := flag.Bool("v", false, "prints current program version")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	multistream := flag.Bool("multistream", false, "treat input as a multistream bz2 dump and decode chunks in parallel")
+	indexFile := flag.String("index", "", "multistream index file (defaults to the dump's own *-multistream-index.txt.bz2)")
+	format := flag.String("format", "auto", "input format: xml, json or auto (detect from filename)")
+	properties := flag.String("properties", "", "comma separated list of claim properties to keep, e.g. P31,P279 (default: keep all)")
+	sitelinks := flag.String("sitelinks", "", "comma separated list of sitelinks to keep, e.g. enwiki,dewiki (default: keep all)")
+	languages := flag.String("languages", "", "comma separated list of languages to keep in labels/descriptions/aliases, e.g. en,de (default: keep all)")
+	outputDir := flag.String("output-dir", "", "write gzip-compressed, sharded output here instead of stdout (see -shards)")
+	shards := flag.Int("shards", 1, "number of output shards when -output-dir is set")
+	queueSize := flag.Int("queue-size", 0, "buffer size of the output channel, to let a slow writer apply backpressure instead of growing memory unbounded")
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
@@ -111,21 +304,27 @@ func main() {
 	}
 
 	if len(flag.Args()) != 1 {
-		log.Fatalln("Usage: wikidatatojson [OPTIONS] WIKIPEDIA-DUMP-XML")
+		log.Fatalln("Usage: wikidatatojson [OPTIONS] WIKIPEDIA-DUMP")
 	}
 
-	// the input XML
 	filename := flag.Args()[0]
-	handle, err := os.Open(filename)
+
+	projection := Projection{
+		Properties: parseCommaSet(*properties),
+		Sitelinks:  parseCommaSet(*sitelinks),
+		Languages:  parseCommaSet(*languages),
+	}
+
+	source, err := wikitools.NewInputSource(*format, filename, *multistream, *indexFile, *numWorkers)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer handle.Close()
 
-	// the parsed XML pages channel
+	// the parsed pages channel
 	queue := make(chan *wikitools.Page)
-	// output channel
-	results := make(chan *string)
+	// output channel, buffered so a slow writer applies backpressure to the tokenizer
+	// instead of letting results balloon in memory
+	results := make(chan *ConversionResult, *queueSize)
 	// done chan
 	done := make(chan bool)
 
@@ -134,40 +333,41 @@ func main() {
 	// workers
 	for i := 0; i < *numWorkers; i++ {
 		wg.Add(1)
-		go WikidataJsonConverter(queue, results, filterRx, &wg)
+		go WikidataJsonConverter(queue, results, filterRx, projection, &wg)
 	}
 
 	// output writer
-	go wikitools.FanInLineWriter(os.Stdout, results, done)
-
-	// XML decoder
-	decoder := xml.NewDecoder(handle)
-	var inElement string
-
-	for {
-		// Read tokens from the XML document in a stream.
-		t, _ := decoder.Token()
-		if t == nil {
-			break
-		}
-		// Inspect the type of the token just read.
-		switch se := t.(type) {
-		case xml.StartElement:
-			// If we just read a StartElement token
-			inElement = se.Name.Local
-			// ...and its name is "page"
-			if inElement == "page" {
-				var p wikitools.Page
-				// decode a whole chunk of following XML into the
-				// variable p which is a Page (se above)
-				decoder.DecodeElement(&p, &se)
-				queue <- &p
-			}
-		default:
+	var shardedWriter *ShardedWriter
+	if *outputDir != "" {
+		if *shards < 1 {
+			log.Fatalf("-shards must be at least 1, got %d", *shards)
+		}
+		shardedWriter, err = NewShardedWriter(*outputDir, *shards)
+		if err != nil {
+			log.Fatalln(err)
 		}
+		go fanInSharded(results, shardedWriter, done)
+	} else {
+		go fanInStdout(results, done)
 	}
-	close(queue)
+
+	// Regardless of whether source.Pages succeeds, the in-flight workers and output writer
+	// must still be drained so every shard's gzip.Writer gets flushed and closed; exiting
+	// immediately on a read error would leave shard files as truncated, invalid gzip streams
+	// and throw away everything converted so far.
+	pagesErr := source.Pages(queue)
+
 	wg.Wait()
 	close(results)
 	<-done
+
+	if shardedWriter != nil {
+		if err := shardedWriter.Close(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if pagesErr != nil {
+		log.Fatalln(pagesErr)
+	}
 }
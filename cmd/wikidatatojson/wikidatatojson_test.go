@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectionApply(t *testing.T) {
+	container := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"P31":  "instance of statements",
+			"P279": "subclass of statements",
+		},
+		"sitelinks": map[string]interface{}{
+			"enwiki": "En",
+			"dewiki": "De",
+		},
+		"labels": map[string]interface{}{
+			"en": "English label",
+			"de": "German label",
+		},
+	}
+
+	p := Projection{
+		Properties: parseCommaSet("P31"),
+		Sitelinks:  parseCommaSet("enwiki"),
+		Languages:  parseCommaSet("en"),
+	}
+	p.apply(container)
+
+	claims := container["claims"].(map[string]interface{})
+	if _, ok := claims["P31"]; !ok {
+		t.Errorf("expected P31 to survive projection")
+	}
+	if _, ok := claims["P279"]; ok {
+		t.Errorf("expected P279 to be dropped by projection")
+	}
+
+	sitelinks := container["sitelinks"].(map[string]interface{})
+	if len(sitelinks) != 1 {
+		t.Errorf("expected 1 sitelink to survive, got %d", len(sitelinks))
+	}
+
+	labels := container["labels"].(map[string]interface{})
+	if len(labels) != 1 {
+		t.Errorf("expected 1 label to survive, got %d", len(labels))
+	}
+}
+
+func TestProjectionApplyEmptyKeepsEverything(t *testing.T) {
+	container := map[string]interface{}{
+		"claims": map[string]interface{}{"P31": "x"},
+	}
+	p := Projection{}
+	if !p.empty() {
+		t.Fatalf("expected zero-value Projection to be empty")
+	}
+	p.apply(container)
+	claims := container["claims"].(map[string]interface{})
+	if _, ok := claims["P31"]; !ok {
+		t.Errorf("empty projection must not drop anything")
+	}
+}
+
+// readShardedResults writes results into a fresh ShardedWriter in dir and returns, for each
+// canonical title found, the index of the shard file it ended up in.
+func readShardedResults(t *testing.T, dir string, results []*ConversionResult, shards int) map[string]int {
+	t.Helper()
+
+	sw, err := NewShardedWriter(dir, shards)
+	if err != nil {
+		t.Fatalf("NewShardedWriter: %v", err)
+	}
+	for _, r := range results {
+		if err := sw.Write(r); err != nil {
+			t.Fatalf("Write(%q): %v", r.CanonicalTitle, err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	found := make(map[string]int)
+	for i := 0; i < shards; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("part-%05d.json.gz", i))
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("open shard %d: %v", i, err)
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("shard %d is not a valid gzip stream: %v", i, err)
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var entity struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &entity); err != nil {
+				t.Fatalf("shard %d line %q is not valid JSON: %v", i, scanner.Text(), err)
+			}
+			found[entity.ID] = i
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("scanning shard %d: %v", i, err)
+		}
+	}
+	return found
+}
+
+// TestShardedWriterRoutingIsStable drives NewShardedWriter/Write end to end (not just the
+// crc32 formula in isolation) and checks that the same canonical title always lands in the
+// same shard file across independent writer instances, and that every shard is a valid,
+// fully-flushed gzip stream.
+func TestShardedWriterRoutingIsStable(t *testing.T) {
+	results := []*ConversionResult{
+		{CanonicalTitle: "Q42", Line: `{"id":"Q42"}`},
+		{CanonicalTitle: "Q1", Line: `{"id":"Q1"}`},
+		{CanonicalTitle: "Q100000", Line: `{"id":"Q100000"}`},
+		{CanonicalTitle: "Q2", Line: `{"id":"Q2"}`},
+	}
+
+	first := readShardedResults(t, t.TempDir(), results, 4)
+	second := readShardedResults(t, t.TempDir(), results, 4)
+
+	if len(first) != len(results) {
+		t.Fatalf("expected every result to land in exactly one shard, got %v", first)
+	}
+	for _, r := range results {
+		if first[r.CanonicalTitle] != second[r.CanonicalTitle] {
+			t.Errorf("routing for %q is not stable: %d != %d", r.CanonicalTitle, first[r.CanonicalTitle], second[r.CanonicalTitle])
+		}
+	}
+}
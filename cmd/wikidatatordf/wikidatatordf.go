@@ -0,0 +1,366 @@
+// Copyright 2014 by Leipzig University Library, http://ub.uni-leipzig.de
+//                   The Finc Authors, http://finc.info
+//                   Martin Czygan, <martin.czygan@uni-leipzig.de>
+//
+// This file is part of some open source application.
+//
+// Some open source application is free software: you can redistribute
+// it and/or modify it under the terms of the GNU General Public
+// License as published by the Free Software Foundation, either
+// version 3 of the License, or (at your option) any later version.
+//
+// Some open source application is distributed in the hope that it will
+// be useful, but WITHOUT ANY WARRANTY; without even the implied warranty
+// of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Foobar.  If not, see <http://www.gnu.org/licenses/>.
+//
+// @license GPL-3.0+ <http://spdx.org/licenses/GPL-3.0+>
+//
+//
+// wikidatatordf converts a wikidata dump (XML or line-delimited JSON) into RDF triples
+// (N-Triples or Turtle).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/miku/wikitools"
+)
+
+const (
+	wikidataEntityPrefix = "http://www.wikidata.org/entity/"
+	wikidataPropPrefix   = "http://www.wikidata.org/prop/direct/"
+	rdfsLabelPredicate   = "http://www.w3.org/2000/01/rdf-schema#label"
+	schemaDescPredicate  = "http://schema.org/description"
+	xsdNamespace         = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// Triple is a single RDF statement. Object is already a formatted term (an IRI in "<...>" or
+// a literal with its language tag or "xsd:" prefixed datatype attached).
+type Triple struct {
+	Subject   string
+	Predicate string
+	Object    string
+}
+
+// Format renders the triple as N-Triples (full IRIs) or, for emit=="turtle", with the wd:/wdt:
+// prefixes declared by turtlePrefixes.
+func (t Triple) Format(emit string) string {
+	if emit == "turtle" {
+		return fmt.Sprintf("%s %s %s .", turtleTerm(t.Subject, wikidataEntityPrefix, "wd"), turtleTerm(t.Predicate, wikidataPropPrefix, "wdt"), t.Object)
+	}
+	object := t.Object
+	// N-Triples has no prefixes, so an "xsd:" datatype is expanded to its full IRI.
+	if i := strings.LastIndex(object, `"`); i >= 0 && strings.HasPrefix(object[i+1:], "^^xsd:") {
+		object = object[:i+1] + "^^<" + xsdNamespace + object[i+7:] + ">"
+	}
+	return fmt.Sprintf("<%s> <%s> %s .", t.Subject, t.Predicate, object)
+}
+
+// turtleTerm shortens an IRI to a prefixed name when it falls under base, maps the label and
+// description predicates to rdfs:label and schema:description, and otherwise falls back to a
+// full IRI term.
+func turtleTerm(iri, base, prefix string) string {
+	if strings.HasPrefix(iri, base) {
+		return prefix + ":" + strings.TrimPrefix(iri, base)
+	}
+	if iri == rdfsLabelPredicate {
+		return "rdfs:label"
+	}
+	if iri == schemaDescPredicate {
+		return "schema:description"
+	}
+	return "<" + iri + ">"
+}
+
+// turtlePrefixes is the Turtle prefix header, written once before any triples.
+const turtlePrefixes = `@prefix wd: <http://www.wikidata.org/entity/> .
+@prefix wdt: <http://www.wikidata.org/prop/direct/> .
+@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+@prefix schema: <http://schema.org/> .
+@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+`
+
+// formatWikidataTime renders a Wikidata time value as a typed literal. Wikidata times carry
+// an explicit "+"/"-" sign (e.g. "+1867-07-01T00:00:00Z"), but xsd:dateTime's lexical space
+// only permits an optional leading "-", so a "+" sign is stripped. Claims with year (9) or
+// year-month (10) precision are downgraded to xsd:gYear/xsd:gYearMonth instead of asserting
+// a fabricated day/month of "01"; every other precision is rendered as xsd:dateTime.
+func formatWikidataTime(vm map[string]interface{}) string {
+	raw, _ := vm["time"].(string)
+	sign := ""
+	rest := raw
+	if strings.HasPrefix(rest, "+") || strings.HasPrefix(rest, "-") {
+		if rest[0] == '-' {
+			sign = "-"
+		}
+		rest = rest[1:]
+	}
+
+	precision := -1
+	if p, ok := vm["precision"].(json.Number); ok {
+		if n, err := p.Int64(); err == nil {
+			precision = int(n)
+		}
+	}
+
+	parts := strings.SplitN(rest, "-", 3)
+	switch {
+	case precision == 9 && len(parts) >= 1:
+		return fmt.Sprintf("%q^^xsd:gYear", sign+parts[0])
+	case precision == 10 && len(parts) >= 2:
+		return fmt.Sprintf("%q^^xsd:gYearMonth", sign+parts[0]+"-"+parts[1])
+	default:
+		return fmt.Sprintf("%q^^xsd:dateTime", sign+rest)
+	}
+}
+
+// datavalueObject translates a Wikidata mainsnak datavalue into an RDF object term, picking
+// the encoding by datatype: entity IRIs for wikibase-item, typed literals for
+// time/quantity/monolingualtext, and xsd:string for plain strings.
+func datavalueObject(datatype string, datavalue map[string]interface{}) (string, bool) {
+	value := datavalue["value"]
+	switch datatype {
+	case "wikibase-item":
+		vm, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		id, _ := vm["id"].(string)
+		if id == "" {
+			return "", false
+		}
+		return fmt.Sprintf("<%s%s>", wikidataEntityPrefix, id), true
+	case "time":
+		vm, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		return formatWikidataTime(vm), true
+	case "quantity":
+		vm, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		amount, _ := vm["amount"].(string)
+		return fmt.Sprintf("%q^^xsd:decimal", amount), true
+	case "monolingualtext":
+		vm, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		text, _ := vm["text"].(string)
+		lang, _ := vm["language"].(string)
+		return fmt.Sprintf("%q@%s", text, lang), true
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%q^^xsd:string", s), true
+	default:
+		return "", false
+	}
+}
+
+// entityTriples walks the standard Wikidata entity shape (claims, labels, descriptions) and
+// returns the triples it implies for qid, restricted to languages when non-empty.
+func entityTriples(qid string, entity map[string]interface{}, languages map[string]bool) []Triple {
+	var triples []Triple
+	subject := wikidataEntityPrefix + qid
+
+	if claims, ok := entity["claims"].(map[string]interface{}); ok {
+		for prop, statementsIface := range claims {
+			statements, ok := statementsIface.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, stmtIface := range statements {
+				stmt, ok := stmtIface.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				mainsnak, ok := stmt["mainsnak"].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				datatype, _ := mainsnak["datatype"].(string)
+				datavalue, ok := mainsnak["datavalue"].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				object, ok := datavalueObject(datatype, datavalue)
+				if !ok {
+					continue
+				}
+				triples = append(triples, Triple{
+					Subject:   subject,
+					Predicate: wikidataPropPrefix + prop,
+					Object:    object,
+				})
+			}
+		}
+	}
+
+	for field, predicate := range map[string]string{"labels": rdfsLabelPredicate, "descriptions": schemaDescPredicate} {
+		values, ok := entity[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for lang, vIface := range values {
+			if len(languages) > 0 && !languages[lang] {
+				continue
+			}
+			v, ok := vIface.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			text, _ := v["value"].(string)
+			triples = append(triples, Triple{
+				Subject:   subject,
+				Predicate: predicate,
+				Object:    fmt.Sprintf("%q@%s", text, lang),
+			})
+		}
+	}
+
+	return triples
+}
+
+// WikidataRdfConverter decodes pages into their Wikidata JSON entity shape and emits one RDF
+// triple per line into out, in the requested serialization.
+func WikidataRdfConverter(in chan *wikitools.Page, out chan *string, filter *regexp.Regexp, languages map[string]bool, emit string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for page := range in {
+		canonicalTitle := wikitools.CanonicalizeTitle(page.Title)
+		if filter.MatchString(canonicalTitle) || page.Redir.Title != "" {
+			continue
+		}
+
+		var entity map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(page.Text))
+		dec.UseNumber()
+		if err := dec.Decode(&entity); err == io.EOF {
+			continue
+		} else if err != nil {
+			log.Printf("skipping malformed entity: %s", err)
+			continue
+		}
+
+		qid, _ := entity["id"].(string)
+		if qid == "" {
+			qid = canonicalTitle
+		}
+
+		for _, t := range entityTriples(qid, entity, languages) {
+			line := t.Format(emit)
+			out <- &line
+		}
+	}
+}
+
+// parseCommaSet turns a comma-separated flag value ("en,de") into a lookup set, or nil for
+// an empty string so callers can treat it as "no restriction".
+func parseCommaSet(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			set[part] = true
+		}
+	}
+	return set
+}
+
+func main() {
+
+	filter := flag.String("filter", "^file:.*|^talk:.*|^special:.*|^wikipedia:.*|^wiktionary:.*|^user:.*|^user_talk:.*", "regex for pages to skip")
+	version := flag.Bool("v", false, "prints current program version")
+	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	languages := flag.String("languages", "", "comma separated list of languages to emit labels/descriptions for (default: keep all)")
+	emit := flag.String("emit", "ntriples", "output serialization: ntriples or turtle")
+	multistream := flag.Bool("multistream", false, "treat input as a multistream bz2 dump and decode chunks in parallel")
+	indexFile := flag.String("index", "", "multistream index file (defaults to the dump's own *-multistream-index.txt.bz2)")
+	format := flag.String("format", "auto", "input format: xml, json or auto (detect from filename)")
+
+	flag.Usage = func() {
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	filterRx, err := regexp.Compile(*filter)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	runtime.GOMAXPROCS(*numWorkers)
+
+	if *version {
+		fmt.Println(wikitools.Version)
+		os.Exit(0)
+	}
+
+	if *emit != "ntriples" && *emit != "turtle" {
+		log.Fatalf("unknown -emit: %s", *emit)
+	}
+
+	if len(flag.Args()) != 1 {
+		log.Fatalln("Usage: wikidatatordf [OPTIONS] WIKIDATA-DUMP")
+	}
+
+	filename := flag.Args()[0]
+
+	source, err := wikitools.NewInputSource(*format, filename, *multistream, *indexFile, *numWorkers)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *emit == "turtle" {
+		fmt.Print(turtlePrefixes)
+	}
+
+	languageSet := parseCommaSet(*languages)
+
+	// the parsed pages channel
+	queue := make(chan *wikitools.Page)
+	// output channel
+	results := make(chan *string)
+	// done chan
+	done := make(chan bool)
+
+	var wg sync.WaitGroup
+
+	// workers
+	for i := 0; i < *numWorkers; i++ {
+		wg.Add(1)
+		go WikidataRdfConverter(queue, results, filterRx, languageSet, *emit, &wg)
+	}
+
+	// output writer
+	go wikitools.FanInLineWriter(os.Stdout, results, done)
+
+	if err := source.Pages(queue); err != nil {
+		log.Fatalln(err)
+	}
+
+	wg.Wait()
+	close(results)
+	<-done
+}
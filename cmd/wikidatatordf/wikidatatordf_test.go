@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeValue parses a JSON snippet with UseNumber so precision fields come back as
+// json.Number, matching what entityTriples sees from a real dump decode.
+func decodeValue(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return v
+}
+
+func TestDatavalueObjectWikibaseItem(t *testing.T) {
+	dv := decodeValue(t, `{"value": {"entity-type": "item", "id": "Q5"}, "type": "wikibase-entityid"}`)
+	object, ok := datavalueObject("wikibase-item", dv)
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if want := "<http://www.wikidata.org/entity/Q5>"; object != want {
+		t.Errorf("got %q, want %q", object, want)
+	}
+}
+
+func TestDatavalueObjectTimeStripsSign(t *testing.T) {
+	dv := decodeValue(t, `{"value": {"time": "+1867-07-01T00:00:00Z", "precision": 11}, "type": "time"}`)
+	object, ok := datavalueObject("time", dv)
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if strings.Contains(object, "+1867") {
+		t.Errorf("leading sign was not stripped: %q", object)
+	}
+	if want := `"1867-07-01T00:00:00Z"^^xsd:dateTime`; object != want {
+		t.Errorf("got %q, want %q", object, want)
+	}
+}
+
+func TestDatavalueObjectTimeYearPrecision(t *testing.T) {
+	dv := decodeValue(t, `{"value": {"time": "+1867-01-01T00:00:00Z", "precision": 9}, "type": "time"}`)
+	object, ok := datavalueObject("time", dv)
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if want := `"1867"^^xsd:gYear`; object != want {
+		t.Errorf("got %q, want %q", object, want)
+	}
+}
+
+func TestDatavalueObjectString(t *testing.T) {
+	dv := decodeValue(t, `{"value": "hello", "type": "string"}`)
+	object, ok := datavalueObject("string", dv)
+	if !ok {
+		t.Fatalf("expected ok")
+	}
+	if want := `"hello"^^xsd:string`; object != want {
+		t.Errorf("got %q, want %q", object, want)
+	}
+}
+
+func TestEntityTriplesFiltersLanguages(t *testing.T) {
+	entity := decodeValue(t, `{
+		"id": "Q42",
+		"claims": {},
+		"labels": {
+			"en": {"language": "en", "value": "Douglas Adams"},
+			"de": {"language": "de", "value": "Douglas Adams"}
+		}
+	}`)
+	triples := entityTriples("Q42", entity, map[string]bool{"en": true})
+	if len(triples) != 1 {
+		t.Fatalf("expected 1 triple after language filter, got %d: %v", len(triples), triples)
+	}
+	if !strings.Contains(triples[0].Object, "@en") {
+		t.Errorf("expected surviving label to be tagged @en, got %q", triples[0].Object)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2014 by Leipzig University Library, http://ub.uni-leipzig.de
+//                   The Finc Authors, http://finc.info
+//                   Martin Czygan, <martin.czygan@uni-leipzig.de>
+//
+// This file is part of some open source application.
+//
+// Some open source application is free software: you can redistribute
+// it and/or modify it under the terms of the GNU General Public
+// License as published by the Free Software Foundation, either
+// version 3 of the License, or (at your option) any later version.
+//
+// Some open source application is distributed in the hope that it will
+// be useful, but WITHOUT ANY WARRANTY; without even the implied warranty
+// of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Foobar.  If not, see <http://www.gnu.org/licenses/>.
+//
+// @license GPL-3.0+ <http://spdx.org/licenses/GPL-3.0+>
+//
+package wikitools
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+)
+
+// InputSource streams Page values from a dump on disk into queue, regardless of the dump's
+// on-disk encoding, closing queue once exhausted. wikidatatojson and wikidatatordf both
+// drive their worker pools off an InputSource instead of hand-rolling their own decode loop.
+type InputSource interface {
+	Pages(queue chan *Page) error
+}
+
+// nopCloser wraps a Reader that has no Close method of its own (e.g. bzip2.Reader) so it
+// can be handed around as an io.ReadCloser alongside the underlying file it reads from.
+type nopCloser struct {
+	io.Reader
+	underlying io.Closer
+}
+
+func (n nopCloser) Close() error {
+	return n.underlying.Close()
+}
+
+// OpenDumpReader opens filename and, based on its suffix, wraps it with the matching
+// streaming decompressor (bzip2, gzip or xz) so callers never have to pipe dumps through
+// bzcat/zcat/xzcat by hand. Files without a recognized compressed suffix are returned as-is.
+func OpenDumpReader(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(filename, ".bz2"):
+		return nopCloser{Reader: bzip2.NewReader(f), underlying: f}, nil
+	case strings.HasSuffix(filename, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return nopCloser{Reader: gz, underlying: f}, nil
+	case strings.HasSuffix(filename, ".xz"):
+		xr, err := xz.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return nopCloser{Reader: xr, underlying: f}, nil
+	default:
+		return f, nil
+	}
+}
+
+// DetectFormat guesses -format=auto from filename, stripping a compressed suffix first and
+// treating a remaining ".json" extension as the line-delimited Wikidata JSON dump, falling
+// back to the XML export otherwise.
+func DetectFormat(filename string) string {
+	name := filename
+	for _, suf := range []string{".bz2", ".gz", ".xz"} {
+		name = strings.TrimSuffix(name, suf)
+	}
+	if strings.HasSuffix(name, ".json") {
+		return "json"
+	}
+	return "xml"
+}
+
+// MultistreamIndexPath derives the path of the accompanying multistream index file for a
+// "*-pages-articles-multistream.xml.bz2" style dump, e.g.
+// "foo-pages-articles-multistream.xml.bz2" -> "foo-pages-articles-multistream-index.txt.bz2".
+// The base already ends in "-multistream" by Wikimedia's naming convention, so only
+// "-index.txt.bz2" is appended.
+func MultistreamIndexPath(filename string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if strings.HasSuffix(base, ".xml") {
+		base = strings.TrimSuffix(base, ".xml")
+	}
+	return base + "-index.txt.bz2"
+}
+
+// ReadMultistreamIndex parses a multistream index (lines of "offset:pageID:title") into the
+// sorted, deduplicated list of byte offsets at which independent bz2 streams begin.
+func ReadMultistreamIndex(r io.Reader) ([]int64, error) {
+	seen := make(map[int64]bool)
+	var offsets []int64
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		offset, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if !seen[offset] {
+			seen[offset] = true
+			offsets = append(offsets, offset)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+// multistreamChunk is a byte range of the dump file that decompresses as a single,
+// independent bz2 stream of concatenated <page>...</page> fragments.
+type multistreamChunk struct {
+	start, end int64 // end == -1 means "until EOF"
+}
+
+// decodeMultistreamChunk decompresses one independent bz2 stream and parses the bare <page>
+// fragments it contains (no enclosing <mediawiki> root in multistream chunks), sending each
+// one to queue.
+func decodeMultistreamChunk(filename string, c multistreamChunk, queue chan *Page) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(c.start, io.SeekStart); err != nil {
+		return err
+	}
+	var r io.Reader = f
+	if c.end >= 0 {
+		r = io.LimitReader(f, c.end-c.start)
+	}
+
+	wrapped := io.MultiReader(strings.NewReader("<mediawiki>"), bzip2.NewReader(r), strings.NewReader("</mediawiki>"))
+	decoder := xml.NewDecoder(wrapped)
+
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == "page" {
+			var p Page
+			if err := decoder.DecodeElement(&p, &se); err != nil {
+				return err
+			}
+			queue <- &p
+		}
+	}
+	return nil
+}
+
+// DispatchMultistream splits filename into independent bz2 streams at the offsets recorded
+// in its multistream index and decodes numWorkers chunks concurrently, which parallelizes
+// the otherwise single-threaded XML tokenizer.
+func DispatchMultistream(filename string, offsets []int64, queue chan *Page, numWorkers int) error {
+	chunks := make([]multistreamChunk, 0, len(offsets))
+	for i, off := range offsets {
+		end := int64(-1)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		chunks = append(chunks, multistreamChunk{start: off, end: end})
+	}
+
+	jobs := make(chan multistreamChunk)
+	var wg sync.WaitGroup
+	var firstErr error
+	var mu sync.Mutex
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				if err := decodeMultistreamChunk(filename, c, queue); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, c := range chunks {
+		jobs <- c
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// XMLDumpSource reads a standard MediaWiki XML export, optionally split across independent
+// bz2 multistream chunks (see DispatchMultistream).
+type XMLDumpSource struct {
+	Filename    string
+	Multistream bool
+	IndexFile   string
+	NumWorkers  int
+}
+
+// Pages implements InputSource.
+func (s *XMLDumpSource) Pages(queue chan *Page) error {
+	defer close(queue)
+
+	if s.Multistream {
+		idxPath := s.IndexFile
+		if idxPath == "" {
+			idxPath = MultistreamIndexPath(s.Filename)
+		}
+		idxHandle, err := OpenDumpReader(idxPath)
+		if err != nil {
+			return err
+		}
+		offsets, err := ReadMultistreamIndex(idxHandle)
+		idxHandle.Close()
+		if err != nil {
+			return err
+		}
+		return DispatchMultistream(s.Filename, offsets, queue, s.NumWorkers)
+	}
+
+	handle, err := OpenDumpReader(s.Filename)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	decoder := xml.NewDecoder(handle)
+	for {
+		t, _ := decoder.Token()
+		if t == nil {
+			break
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == "page" {
+			var p Page
+			decoder.DecodeElement(&p, &se)
+			queue <- &p
+		}
+	}
+	return nil
+}
+
+// JSONDumpSource reads a Wikidata line-delimited JSON dump (wikidata-*-all.json.bz2), where
+// the file as a whole is a JSON array but each line holds exactly one entity object.
+type JSONDumpSource struct {
+	Filename string
+}
+
+// Pages implements InputSource.
+func (s *JSONDumpSource) Pages(queue chan *Page) error {
+	defer close(queue)
+
+	handle, err := OpenDumpReader(s.Filename)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	scanner := bufio.NewScanner(handle)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimPrefix(line, "[")
+		line = strings.TrimSuffix(line, "]")
+		line = strings.TrimSuffix(line, ",")
+		if line == "" {
+			continue
+		}
+
+		var entity struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal([]byte(line), &entity); err != nil {
+			log.Printf("skipping malformed entity: %s", err)
+			continue
+		}
+		title := entity.Title
+		if title == "" {
+			title = entity.ID
+		}
+		queue <- &Page{Title: title, Text: line}
+	}
+	return scanner.Err()
+}
+
+// NewInputSource resolves -format (xml, json or auto) for filename into the matching
+// InputSource.
+func NewInputSource(format, filename string, multistream bool, indexFile string, numWorkers int) (InputSource, error) {
+	resolved := format
+	if resolved == "auto" {
+		resolved = DetectFormat(filename)
+	}
+	switch resolved {
+	case "xml":
+		return &XMLDumpSource{Filename: filename, Multistream: multistream, IndexFile: indexFile, NumWorkers: numWorkers}, nil
+	case "json":
+		return &JSONDumpSource{Filename: filename}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", resolved)
+	}
+}
@@ -0,0 +1,59 @@
+package wikitools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultistreamIndexPath(t *testing.T) {
+	cases := []struct {
+		filename string
+		want     string
+	}{
+		{
+			filename: "enwiki-20200101-pages-articles-multistream.xml.bz2",
+			want:     "enwiki-20200101-pages-articles-multistream-index.txt.bz2",
+		},
+		{
+			filename: "wikidatawiki-20200101-pages-articles-multistream.xml.bz2",
+			want:     "wikidatawiki-20200101-pages-articles-multistream-index.txt.bz2",
+		},
+	}
+	for _, c := range cases {
+		got := MultistreamIndexPath(c.filename)
+		if got != c.want {
+			t.Errorf("MultistreamIndexPath(%q) = %q, want %q", c.filename, got, c.want)
+		}
+	}
+}
+
+func TestReadMultistreamIndex(t *testing.T) {
+	input := "0:1:Page One\n0:2:Page Two\n1234:3:Page Three\n999:4:Page Four\n"
+	offsets, err := ReadMultistreamIndex(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadMultistreamIndex: %v", err)
+	}
+	want := []int64{0, 999, 1234}
+	if len(offsets) != len(want) {
+		t.Fatalf("got %d offsets, want %d: %v", len(offsets), len(want), offsets)
+	}
+	for i, o := range want {
+		if offsets[i] != o {
+			t.Errorf("offsets[%d] = %d, want %d", i, offsets[i], o)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"wikidata-20200101-all.json.bz2":       "json",
+		"wikidata-20200101-all.json.gz":        "json",
+		"enwiki-20200101-pages-articles.xml.bz2": "xml",
+		"dump.xml":                              "xml",
+	}
+	for filename, want := range cases {
+		if got := DetectFormat(filename); got != want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}